@@ -5,13 +5,17 @@ import "errors"
 type OAuth20ClientConfig struct {
 	Redirects []string `toml:"redirects"`
 	Secret    string   `toml:"secret"`
+	// RequirePKCE forces this client to present a PKCE code_challenge on
+	// the authorization code flow, allowing it to omit Secret (e.g. for
+	// SPAs and native apps that can't keep one confidential).
+	RequirePKCE bool `toml:"require_pkce"`
 }
 
 type OAuth20ClientMapFromConfig map[string]OAuth20ClientConfig
 
 func (m OAuth20ClientMapFromConfig) Get(id string) (*OAuth20ClientData, error) {
 	if data, ok := m[id]; ok {
-		return &OAuth20ClientData{id, data.Redirects, data.Secret}, nil
+		return &OAuth20ClientData{id, data.Redirects, data.Secret, data.RequirePKCE}, nil
 	}
 	return nil, errors.New("Client ID not found")
 }
\ No newline at end of file
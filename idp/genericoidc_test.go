@@ -0,0 +1,148 @@
+package idp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClaimAt(t *testing.T) {
+	claims := map[string]interface{}{
+		"sub": "user1",
+		"realm_access": map[string]interface{}{
+			"roles": []interface{}{"admin", "viewer"},
+		},
+	}
+	cases := []struct {
+		name string
+		path string
+		want interface{}
+	}{
+		{"top-level", "sub", "user1"},
+		{"nested", "realm_access.roles", []interface{}{"admin", "viewer"}},
+		{"missing top-level", "missing", nil},
+		{"missing nested", "realm_access.missing", nil},
+		{"path through a non-map value", "sub.nested", nil},
+		{"path into a missing intermediate", "missing.roles", nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := claimAt(claims, c.path); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("claimAt(claims, %q) = %#v, want %#v", c.path, got, c.want)
+			}
+		})
+	}
+}
+
+func TestClaimRoles(t *testing.T) {
+	cases := []struct {
+		name string
+		in   interface{}
+		want []string
+	}{
+		{"json array of strings", []interface{}{"admin", "viewer"}, []string{"admin", "viewer"}},
+		{"space-separated string", "admin viewer", []string{"admin", "viewer"}},
+		{"array with a non-string element", []interface{}{"admin", 42}, []string{"admin"}},
+		{"empty string", "", nil},
+		{"nil", nil, nil},
+		{"unsupported type", 42, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := claimRoles(c.in)
+			if len(got) == 0 && len(c.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("claimRoles(%#v) = %#v, want %#v", c.in, got, c.want)
+			}
+		})
+	}
+}
+
+// TestGenericOIDCUser replays the claim mapping that User applies (claimAt
+// and claimRoles on a decoded claims map) without calling User itself,
+// since User's token exchange and verification require a live OIDC
+// provider.
+func TestGenericOIDCUser(t *testing.T) {
+	cases := []struct {
+		name       string
+		claims     map[string]interface{}
+		claimUID   string
+		claimRoles string
+		wantUID    string
+		wantRoles  []string
+		wantErr    bool
+	}{
+		{
+			name:     "simple uid claim, no roles configured",
+			claims:   map[string]interface{}{"sub": "user1"},
+			claimUID: "sub",
+			wantUID:  "user1",
+		},
+		{
+			name:       "nested uid and array roles claim",
+			claims:     map[string]interface{}{"email": "user1@example.com", "realm_access": map[string]interface{}{"roles": []interface{}{"admin"}}},
+			claimUID:   "email",
+			claimRoles: "realm_access.roles",
+			wantUID:    "user1@example.com",
+			wantRoles:  []string{"admin"},
+		},
+		{
+			name:       "space-separated roles claim",
+			claims:     map[string]interface{}{"sub": "user1", "scope": "openid admin"},
+			claimUID:   "sub",
+			claimRoles: "scope",
+			wantUID:    "user1",
+			wantRoles:  []string{"openid", "admin"},
+		},
+		{
+			name:     "missing uid claim",
+			claims:   map[string]interface{}{"email": "user1@example.com"},
+			claimUID: "sub",
+			wantErr:  true,
+		},
+		{
+			name:     "uid claim not a string",
+			claims:   map[string]interface{}{"sub": 12345},
+			claimUID: "sub",
+			wantErr:  true,
+		},
+		{
+			name:       "typo'd claim_roles path is not an error, just yields no roles",
+			claims:     map[string]interface{}{"sub": "user1"},
+			claimUID:   "sub",
+			claimRoles: "realm_acces.roles",
+			wantUID:    "user1",
+			wantRoles:  nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			uidClaim := claimAt(c.claims, c.claimUID)
+			uid, ok := uidClaim.(string)
+			if !ok || uid == "" {
+				if !c.wantErr {
+					t.Fatalf("expected a valid uid, got claim %#v", uidClaim)
+				}
+				return
+			}
+			if c.wantErr {
+				t.Fatalf("expected an error, got uid %q", uid)
+			}
+			var roles []string
+			if c.claimRoles != "" {
+				roles = claimRoles(claimAt(c.claims, c.claimRoles))
+			}
+			u := &user{uid: uid, roles: roles}
+			if u.UID() != c.wantUID {
+				t.Errorf("UID() = %q, want %q", u.UID(), c.wantUID)
+			}
+			if len(u.Roles()) == 0 && len(c.wantRoles) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(u.Roles(), c.wantRoles) {
+				t.Errorf("Roles() = %#v, want %#v", u.Roles(), c.wantRoles)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+// An IdP implementation of Google OIC: https://developers.google.com/identity/protocols/OpenIDConnect
+package idp
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// googleDiscoveryURL is Google's well-known OIDC discovery document.
+var googleDiscoveryURL = "https://accounts.google.com/.well-known/openid-configuration"
+
+var googleScope = "openid email"
+
+// googleIDToken is the set of Google-specific claims we care about, on top
+// of the standard claims oidcIDP already validates.
+type googleIDToken struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email"`
+}
+
+// googleIDP is a thin wrapper around oidcIDP, pre-filled with Google's
+// discovery URL. All discovery, JWKS caching and ID token verification is
+// shared with every other OIDC provider through oidcIDP.
+type googleIDP struct {
+	oidc *oidcIDP
+}
+
+// newGoogleIDP is the constructor. It does not validate clientID or
+// clientSecret, and discovery is deferred to first use.
+func newGoogleIDP(clientID string, clientSecret string) *googleIDP {
+	return &googleIDP{oidc: newOIDCIDP(googleDiscoveryURL, clientID, clientSecret, googleScope)}
+}
+
+// AuthnRedirect generates the Google authentication redirect.
+func (g *googleIDP) AuthnRedirect(callbackURL *url.URL) (*url.URL, []byte, error) {
+	return g.oidc.authnRedirect(callbackURL)
+}
+
+// User exchanges the authorization code, verifies the ID token (signature,
+// iss, aud, azp, exp/iat and nonce) and returns the resulting User.
+func (g *googleIDP) User(r *http.Request, state []byte) (User, error) {
+	var idToken googleIDToken
+	if err := g.oidc.exchangeAndVerify(r, state, &idToken); err != nil {
+		return nil, err
+	}
+	return &user{uid: idToken.Subject, roles: []string{"CDE_PLUS"}}, nil
+}
@@ -0,0 +1,56 @@
+// Package idp contains the identity provider implementations used by the
+// goauth2 server. All providers in this package are OpenID Connect based and
+// share their discovery, JWKS caching and ID token verification logic
+// through oidcIDP.
+package idp
+
+import (
+	"fmt"
+
+	"github.com/DatapuntAmsterdam/goauth2/server"
+)
+
+// Authn and User are aliases of the server package's interfaces so provider
+// implementations in this package can satisfy them without importing server
+// directly everywhere.
+type Authn = server.Authn
+type User = server.User
+
+// ProviderConfig is the TOML configuration for a single configured IdP.
+type ProviderConfig struct {
+	// Type selects the provider implementation. "google" configures the
+	// hardcoded Google IdP; "oidc" configures a genericOIDC provider driven
+	// entirely by the fields below.
+	Type         string   `toml:"type"`
+	Issuer       string   `toml:"issuer"`
+	ClientID     string   `toml:"client_id"`
+	ClientSecret string   `toml:"client_secret"`
+	Scopes       []string `toml:"scopes"`
+	ClaimUID     string   `toml:"claim_uid"`
+	ClaimRoles   string   `toml:"claim_roles"`
+}
+
+// Config maps IdP identifiers (as used in the /authorize/<id> path) to their
+// configuration.
+type Config map[string]ProviderConfig
+
+// Load instantiates every configured IdP and returns them keyed by
+// identifier, ready to be registered with server.IdP.
+func Load(config Config) (map[string]Authn, error) {
+	idps := make(map[string]Authn, len(config))
+	for id, c := range config {
+		switch c.Type {
+		case "google":
+			idps[id] = newGoogleIDP(c.ClientID, c.ClientSecret)
+		case "oidc":
+			g, err := newGenericOIDC(c)
+			if err != nil {
+				return nil, fmt.Errorf("idp %q: %s", id, err)
+			}
+			idps[id] = g
+		default:
+			return nil, fmt.Errorf("idp %q: unknown type %q", id, c.Type)
+		}
+	}
+	return idps, nil
+}
@@ -0,0 +1,401 @@
+package idp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockSkewLeeway is the amount of slack allowed when validating exp/iat.
+const clockSkewLeeway = 30 * time.Second
+
+// oidcDiscoveryDoc is the subset of the OIDC discovery document we need.
+type oidcDiscoveryDoc struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// jwk is a single entry of a JSON Web Key Set.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+// tokenResponse is the token endpoint response shape shared by every OIDC
+// provider we talk to.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+}
+
+// idTokenClaims is the set of standard claims validated for every provider.
+// Provider-specific claims are decoded by verifyIDToken into the value its
+// caller passes in.
+type idTokenClaims struct {
+	Issuer          string `json:"iss"`
+	Audience        string `json:"aud"`
+	AuthorizedParty string `json:"azp"`
+	ExpiryTime      int64  `json:"exp"`
+	IssuedAt        int64  `json:"iat"`
+	Nonce           string `json:"nonce"`
+}
+
+// oidcState is the opaque, provider-specific data threaded from
+// AuthnRedirect through to User() via the server's TransientStorage.
+type oidcState struct {
+	Nonce       string
+	RedirectURI string
+}
+
+// oidcIDP is a generic OpenID Connect relying party. It performs discovery,
+// caches the provider's JWKS (refreshing on an unknown `kid`) and validates
+// ID token signatures and standard claims. googleIDP and genericOIDC both
+// embed one, configured with their own discovery URL and scopes.
+type oidcIDP struct {
+	discoveryURL string
+	clientID     string
+	clientSecret string
+	scope        string
+	client       *http.Client
+
+	mutex     sync.Mutex
+	discovery *oidcDiscoveryDoc
+	keys      map[string]interface{} // kid -> public key
+}
+
+// newOIDCIDP constructs an oidcIDP. Discovery is performed lazily on first
+// use so construction never fails on a transient network error.
+func newOIDCIDP(discoveryURL, clientID, clientSecret, scope string) *oidcIDP {
+	return &oidcIDP{
+		discoveryURL: discoveryURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scope:        scope,
+		client:       &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// discover fetches and caches the provider's discovery document.
+func (o *oidcIDP) discover() (*oidcDiscoveryDoc, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.discovery != nil {
+		return o.discovery, nil
+	}
+	resp, err := o.client.Get(o.discoveryURL)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc discovery: unexpected status %d", resp.StatusCode)
+	}
+	var doc oidcDiscoveryDoc
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %s", err)
+	}
+	o.discovery = &doc
+	return o.discovery, nil
+}
+
+// refreshKeys fetches the JWKS document and replaces the cached key set.
+func (o *oidcIDP) refreshKeys() (map[string]interface{}, error) {
+	doc, err := o.discover()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := o.client.Get(doc.JWKSURI)
+	if err != nil {
+		return nil, fmt.Errorf("jwks fetch: %s", err)
+	}
+	defer resp.Body.Close()
+	var set jwkSet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("jwks decode: %s", err)
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	o.mutex.Lock()
+	o.keys = keys
+	o.mutex.Unlock()
+	return keys, nil
+}
+
+// keyFor returns the public key for kid, refreshing the JWKS once if it's
+// not in the cache (to pick up key rotation).
+func (o *oidcIDP) keyFor(kid string) (interface{}, error) {
+	o.mutex.Lock()
+	key, ok := o.keys[kid]
+	o.mutex.Unlock()
+	if ok {
+		return key, nil
+	}
+	keys, err := o.refreshKeys()
+	if err != nil {
+		return nil, err
+	}
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("oidc: no key found for kid %q", kid)
+}
+
+// publicKey decodes a JWK into an *rsa.PublicKey or *ecdsa.PublicKey.
+func (k *jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		eInt := 0
+		for _, b := range e {
+			eInt = eInt<<8 + int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: eInt}, nil
+	case "EC":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		default:
+			return nil, fmt.Errorf("oidc: unsupported curve %q", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("oidc: unsupported key type %q", k.Kty)
+	}
+}
+
+// jwtHeader is the subset of the JOSE header we need.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verifySignature checks sig over signingInput using key, dispatching on the
+// JOSE alg header. Only RS256 and ES256 are supported.
+func verifySignature(alg string, key interface{}, signingInput string, sig []byte) error {
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("oidc: key type does not match alg RS256")
+		}
+		hashed := sha256.Sum256([]byte(signingInput))
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], sig)
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("oidc: key type does not match alg ES256")
+		}
+		if len(sig) != 64 {
+			return errors.New("oidc: malformed ES256 signature")
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		hashed := sha256.Sum256([]byte(signingInput))
+		if !ecdsa.Verify(pub, hashed[:], r, s) {
+			return errors.New("oidc: invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("oidc: unsupported alg %q", alg)
+	}
+}
+
+// verifyIDToken validates the signature and standard claims of a JWT ID
+// token and decodes its payload into claims.
+func (o *oidcIDP) verifyIDToken(idToken string, expectedNonce string, claims interface{}) error {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return errors.New("oidc: malformed id_token")
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("oidc: bad header: %s", err)
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return fmt.Errorf("oidc: bad header: %s", err)
+	}
+	rawPayload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("oidc: bad payload: %s", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("oidc: bad signature: %s", err)
+	}
+	key, err := o.keyFor(header.Kid)
+	if err != nil {
+		return err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return err
+	}
+	var std idTokenClaims
+	if err := json.Unmarshal(rawPayload, &std); err != nil {
+		return fmt.Errorf("oidc: bad claims: %s", err)
+	}
+	doc, err := o.discover()
+	if err != nil {
+		return err
+	}
+	if std.Issuer != doc.Issuer {
+		return fmt.Errorf("oidc: unexpected issuer %q", std.Issuer)
+	}
+	if std.Audience != o.clientID {
+		return fmt.Errorf("oidc: unexpected audience %q", std.Audience)
+	}
+	if std.AuthorizedParty != "" && std.AuthorizedParty != o.clientID {
+		return fmt.Errorf("oidc: unexpected azp %q", std.AuthorizedParty)
+	}
+	now := time.Now()
+	if now.After(time.Unix(std.ExpiryTime, 0).Add(clockSkewLeeway)) {
+		return errors.New("oidc: id_token expired")
+	}
+	if now.Before(time.Unix(std.IssuedAt, 0).Add(-clockSkewLeeway)) {
+		return errors.New("oidc: id_token issued in the future")
+	}
+	if expectedNonce != "" && std.Nonce != expectedNonce {
+		return errors.New("oidc: nonce mismatch")
+	}
+	return json.Unmarshal(rawPayload, claims)
+}
+
+// authnRedirect builds the provider's authentication URL and the opaque
+// per-flow state that must be handed back to exchangeAndVerify (via the
+// provider's User method) at callback time.
+func (o *oidcIDP) authnRedirect(callbackURL *url.URL) (*url.URL, []byte, error) {
+	doc, err := o.discover()
+	if err != nil {
+		return nil, nil, err
+	}
+	nonceBuf := make([]byte, 16)
+	if _, err := rand.Read(nonceBuf); err != nil {
+		return nil, nil, err
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(nonceBuf)
+	authURL, err := url.Parse(doc.AuthorizationEndpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	q := authURL.Query()
+	q.Set("client_id", o.clientID)
+	q.Set("response_type", "code")
+	q.Set("scope", o.scope)
+	q.Set("redirect_uri", callbackURL.String())
+	q.Set("nonce", nonce)
+	if state := callbackURL.Query().Get("state"); state != "" {
+		q.Set("state", state)
+	}
+	authURL.RawQuery = q.Encode()
+	state := oidcState{Nonce: nonce, RedirectURI: callbackURL.String()}
+	encoded, err := encodeState(state)
+	if err != nil {
+		return nil, nil, err
+	}
+	return authURL, encoded, nil
+}
+
+// exchangeAndVerify exchanges the authorization code from r for tokens,
+// verifies the ID token against the opaque state and decodes claims into v.
+func (o *oidcIDP) exchangeAndVerify(r *http.Request, rawState []byte, v interface{}) error {
+	var state oidcState
+	if err := decodeState(rawState, &state); err != nil {
+		return err
+	}
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		return fmt.Errorf("oidc: %s", r.URL.Query().Get("error"))
+	}
+	doc, err := o.discover()
+	if err != nil {
+		return err
+	}
+	data := url.Values{}
+	data.Set("code", code)
+	data.Set("client_id", o.clientID)
+	data.Set("client_secret", o.clientSecret)
+	data.Set("redirect_uri", state.RedirectURI)
+	data.Set("grant_type", "authorization_code")
+	resp, err := o.client.PostForm(doc.TokenEndpoint, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: token endpoint returned status %d", resp.StatusCode)
+	}
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(resp.Body)
+	var tokens tokenResponse
+	if err := json.Unmarshal(buf.Bytes(), &tokens); err != nil {
+		return err
+	}
+	return o.verifyIDToken(tokens.IDToken, state.Nonce, v)
+}
+
+// encodeState/decodeState gob-encode the opaque state threaded through
+// TransientStorage between AuthnRedirect and User, mirroring the encoding
+// used for authorizationState in server/state.go.
+func encodeState(state oidcState) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(state); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeState(raw []byte, state *oidcState) error {
+	return gob.NewDecoder(bytes.NewReader(raw)).Decode(state)
+}
@@ -0,0 +1,151 @@
+package idp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestVerifySignatureRS256(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := "header.payload"
+	hashed := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifySignature("RS256", &priv.PublicKey, signingInput, sig); err != nil {
+		t.Errorf("valid RS256 signature rejected: %s", err)
+	}
+	tampered := append([]byte{}, sig...)
+	tampered[0] ^= 0xFF
+	if err := verifySignature("RS256", &priv.PublicKey, signingInput, tampered); err == nil {
+		t.Error("tampered RS256 signature accepted")
+	}
+	if err := verifySignature("RS256", &priv.PublicKey, "different input", sig); err == nil {
+		t.Error("signature over a different input accepted")
+	}
+}
+
+func TestVerifySignatureES256(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := "header.payload"
+	hashed := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, priv, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// verifySignature expects the fixed-width 32+32 byte r||s encoding.
+	sig := padTo64(r, s)
+	if err := verifySignature("ES256", &priv.PublicKey, signingInput, sig); err != nil {
+		t.Errorf("valid ES256 signature rejected: %s", err)
+	}
+	tampered := append([]byte{}, sig...)
+	tampered[0] ^= 0xFF
+	if err := verifySignature("ES256", &priv.PublicKey, signingInput, tampered); err == nil {
+		t.Error("tampered ES256 signature accepted")
+	}
+}
+
+func padTo64(r, s interface{ Bytes() []byte }) []byte {
+	buf := make([]byte, 64)
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(buf[32-len(rb):32], rb)
+	copy(buf[64-len(sb):64], sb)
+	return buf
+}
+
+// TestVerifyIDTokenRS256 exercises the full path exercised by the real
+// login flow: a JWT signed with RS256, with a key only present in the
+// cache (no network access needed).
+func TestVerifyIDTokenRS256(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &oidcIDP{
+		clientID:  "client1",
+		discovery: &oidcDiscoveryDoc{Issuer: "https://issuer.example"},
+		keys:      map[string]interface{}{"kid1": &priv.PublicKey},
+	}
+	now := time.Now()
+	claims := idTokenClaims{
+		Issuer:     "https://issuer.example",
+		Audience:   "client1",
+		ExpiryTime: now.Add(time.Hour).Unix(),
+		IssuedAt:   now.Unix(),
+		Nonce:      "nonce123",
+	}
+	token := signRS256(t, priv, "kid1", claims)
+	var out idTokenClaims
+	if err := o.verifyIDToken(token, "nonce123", &out); err != nil {
+		t.Fatalf("valid id_token rejected: %s", err)
+	}
+	// Wrong nonce must be rejected.
+	if err := o.verifyIDToken(token, "wrong-nonce", &out); err == nil {
+		t.Error("id_token with mismatched nonce accepted")
+	}
+	// A tampered payload (still well-formed base64/JSON) must fail signature
+	// verification rather than silently re-validating the altered claims.
+	tamperedClaims := claims
+	tamperedClaims.Audience = "someone-else"
+	tampered := swapPayload(token, tamperedClaims)
+	if err := o.verifyIDToken(tampered, "nonce123", &out); err == nil {
+		t.Error("id_token with tampered payload accepted")
+	}
+}
+
+func signRS256(t *testing.T, priv *rsa.PrivateKey, kid string, claims idTokenClaims) string {
+	t.Helper()
+	header, err := json.Marshal(jwtHeader{Alg: "RS256", Kid: kid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hashed := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// swapPayload replaces a signed token's payload without re-signing it, to
+// simulate an attacker tampering with the claims in transit.
+func swapPayload(token string, claims idTokenClaims) string {
+	parts := splitJWT(token)
+	payload, _ := json.Marshal(claims)
+	return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+}
+
+func splitJWT(token string) [3]string {
+	var parts [3]string
+	start := 0
+	idx := 0
+	for i := 0; i < len(token); i++ {
+		if token[i] == '.' {
+			parts[idx] = token[start:i]
+			start = i + 1
+			idx++
+		}
+	}
+	parts[idx] = token[start:]
+	return parts
+}
@@ -0,0 +1,16 @@
+package idp
+
+// user is the concrete server.User implementation shared by every provider
+// in this package.
+type user struct {
+	uid   string
+	roles []string
+}
+
+func (u *user) UID() string {
+	return u.uid
+}
+
+func (u *user) Roles() []string {
+	return u.roles
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// accessTokenEncoder mints signed JWT access tokens (HS256).
+type accessTokenEncoder struct {
+	secret   []byte
+	lifetime int64
+	issuer   string
+}
+
+func newAccessTokenEncoder(secret []byte, lifetime int64, issuer string) *accessTokenEncoder {
+	return &accessTokenEncoder{secret, lifetime, issuer}
+}
+
+type accessTokenClaims struct {
+	Issuer   string   `json:"iss"`
+	Subject  string   `json:"sub"`
+	ClientId string   `json:"client_id"`
+	Scope    []string `json:"scope,omitempty"`
+	IssuedAt int64    `json:"iat"`
+	Expiry   int64    `json:"exp"`
+}
+
+// Encode mints a JWT access token for uid (the empty string for tokens that
+// are not associated with a user, e.g. client_credentials grants) scoped to
+// clientId with the given scope.
+func (e *accessTokenEncoder) Encode(uid string, clientId string, scope []string) (string, error) {
+	now := time.Now().Unix()
+	claims := accessTokenClaims{
+		Issuer:   e.issuer,
+		Subject:  uid,
+		ClientId: clientId,
+		Scope:    scope,
+		IssuedAt: now,
+		Expiry:   now + e.lifetime,
+	}
+	header := []byte(`{"alg":"HS256","typ":"JWT"}`)
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, e.secret)
+	mac.Write([]byte(signingInput))
+	sig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	return signingInput + "." + sig, nil
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAuthorizationCodeServer() *Server {
+	return &Server{
+		store: newMemStore(),
+		clientMap: fixedClientMap{
+			"confidential-pkce-client": {Id: "confidential-pkce-client", Secret: "shh", RequirePKCE: true},
+			"spa-client":               {Id: "spa-client", RequirePKCE: true},
+		},
+		accessTokenEnc:       newAccessTokenEncoder([]byte("secret"), 3600, "goauth2"),
+		refreshStore:         newRefreshTokenMap(),
+		refreshTokenLifetime: time.Hour,
+	}
+}
+
+func saveCodeGrant(t *testing.T, s *Server, code string, grant codeGrant) {
+	t.Helper()
+	encoded, err := json.Marshal(grant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.Set("code:"+code, string(encoded), 60); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func postAuthorizationCode(s *Server, form url.Values) *httptest.ResponseRecorder {
+	form.Set("grant_type", "authorization_code")
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	(&tokenHandler{s}).ServeHTTP(w, r)
+	return w
+}
+
+// TestHandleAuthorizationCodeConfidentialClientStillNeedsSecret verifies a
+// client that has both a configured Secret and RequirePKCE (opting into
+// PKCE as defense-in-depth, not in place of a secret) cannot skip presenting
+// client_secret just by supplying a valid code_verifier.
+func TestHandleAuthorizationCodeConfidentialClientStillNeedsSecret(t *testing.T) {
+	s := newTestAuthorizationCodeServer()
+	saveCodeGrant(t, s, "authzcode1", codeGrant{
+		ClientId:            "confidential-pkce-client",
+		RedirectURI:         "https://app.example/cb",
+		UID:                 "user1",
+		CodeChallenge:       "challenge",
+		CodeChallengeMethod: "plain",
+	})
+	w := postAuthorizationCode(s, url.Values{
+		"client_id":     {"confidential-pkce-client"},
+		"code":          {"authzcode1"},
+		"redirect_uri":  {"https://app.example/cb"},
+		"code_verifier": {"challenge"},
+	})
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a confidential client omitting client_secret to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestHandleAuthorizationCodePublicClientAuthenticatesWithPKCE verifies a
+// client with no configured secret can still authenticate via PKCE alone.
+func TestHandleAuthorizationCodePublicClientAuthenticatesWithPKCE(t *testing.T) {
+	s := newTestAuthorizationCodeServer()
+	saveCodeGrant(t, s, "authzcode2", codeGrant{
+		ClientId:            "spa-client",
+		RedirectURI:         "https://app.example/cb",
+		UID:                 "user1",
+		CodeChallenge:       "challenge",
+		CodeChallengeMethod: "plain",
+	})
+	w := postAuthorizationCode(s, url.Values{
+		"client_id":     {"spa-client"},
+		"code":          {"authzcode2"},
+		"redirect_uri":  {"https://app.example/cb"},
+		"code_verifier": {"challenge"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected a public client with a valid code_verifier to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newTestClientCredentialsServer() *Server {
+	return &Server{
+		clientMap: fixedClientMap{
+			"m2m-client":       {Id: "m2m-client", Secret: "shh", GrantType: "client_credentials"},
+			"no-secret-client": {Id: "no-secret-client", GrantType: "client_credentials"},
+		},
+		authz:          &stubAuthz{userScopes: &allowListScopeSet{allowed: map[string]bool{"read": true}}},
+		accessTokenEnc: newAccessTokenEncoder([]byte("secret"), 3600, "goauth2"),
+	}
+}
+
+func postClientCredentials(s *Server, form url.Values) *httptest.ResponseRecorder {
+	form.Set("grant_type", "client_credentials")
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	(&tokenHandler{s}).ServeHTTP(w, r)
+	return w
+}
+
+// TestHandleClientCredentialsRejectsBlankSecretClient verifies that a client
+// configured with no secret can't authenticate by simply omitting
+// client_secret from the request, which would otherwise compare "" == "".
+func TestHandleClientCredentialsRejectsBlankSecretClient(t *testing.T) {
+	s := newTestClientCredentialsServer()
+	w := postClientCredentials(s, url.Values{"client_id": {"no-secret-client"}})
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "unauthorized_client") {
+		t.Fatalf("expected unauthorized_client error, got %s", w.Body.String())
+	}
+}
+
+// TestHandleClientCredentialsRejectsWrongSecret verifies an incorrect secret
+// is still rejected for a client that does have one configured.
+func TestHandleClientCredentialsRejectsWrongSecret(t *testing.T) {
+	s := newTestClientCredentialsServer()
+	w := postClientCredentials(s, url.Values{"client_id": {"m2m-client"}, "client_secret": {"wrong"}})
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "invalid_client") {
+		t.Fatalf("expected invalid_client error, got %s", w.Body.String())
+	}
+}
+
+// TestHandleClientCredentialsSuccess verifies a correctly authenticated
+// client is granted the intersection of its requested and authorized scope.
+func TestHandleClientCredentialsSuccess(t *testing.T) {
+	s := newTestClientCredentialsServer()
+	w := postClientCredentials(s, url.Values{
+		"client_id":     {"m2m-client"},
+		"client_secret": {"shh"},
+		"scope":         {"read write"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"scope":"read"`) {
+		t.Fatalf("expected scope narrowed to 'read', got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultDeviceCodeLifetime and defaultPollInterval are used when the
+// corresponding server options aren't set.
+const (
+	defaultDeviceCodeLifetime = 10 * time.Minute
+	defaultPollInterval       = 5 * time.Second
+	defaultUserCodeCharset    = "BCDFGHJKLMNPQRSTVWXZ0123456789"
+	userCodeLength            = 8
+)
+
+// deviceStatus is the lifecycle state of a device authorization request.
+type deviceStatus string
+
+const (
+	deviceStatusPending  deviceStatus = "pending"
+	deviceStatusApproved deviceStatus = "approved"
+	deviceStatusDenied   deviceStatus = "denied"
+)
+
+// deviceRecord is the pending (or resolved) state of one device
+// authorization request, persisted in TransientStorage keyed by its device
+// code.
+type deviceRecord struct {
+	ClientId   string
+	Scope      []string
+	UserCode   string
+	Status     deviceStatus
+	IdP        string
+	IdPState   []byte
+	UID        string
+	Roles      []string
+	ExpiresAt  int64
+	LastPolled int64
+}
+
+func deviceCodeKey(code string) string    { return "device:" + code }
+func userCodeIndexKey(code string) string { return "device_user_code:" + code }
+
+// deviceCallbackStateLifetime bounds how long a browser/IdP correlation id
+// (see deviceCallbackStateKey) remains redeemable.
+const deviceCallbackStateLifetime = 10 * time.Minute
+
+// deviceCallbackStateKey maps a single-use browser correlation id to the
+// device_code it was issued for, so device_code itself never has to leave
+// the server (it's a long-lived bearer value redeemable for tokens, and
+// would otherwise end up in the IdP's logs, the user's browser history, and
+// Referer headers as the OAuth state parameter).
+func deviceCallbackStateKey(id string) string { return "device_callback_state:" + id }
+
+// deviceAuthorizationHandler implements POST /device_authorization (RFC
+// 8628 section 3.1/3.2).
+type deviceAuthorizationHandler struct {
+	server *Server
+}
+
+func (h *deviceAuthorizationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := h.server
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	clientId := r.Form.Get("client_id")
+	if clientId == "" {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	if _, err := s.clientMap.Get(clientId); err != nil {
+		writeTokenError(w, http.StatusBadRequest, "invalid_client")
+		return
+	}
+	deviceCode, err := randomToken(32)
+	if err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	userCode, err := randomUserCode(s.userCodeCharset)
+	if err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	record := deviceRecord{
+		ClientId:  clientId,
+		Scope:     splitScope(r.Form.Get("scope")),
+		UserCode:  userCode,
+		Status:    deviceStatusPending,
+		ExpiresAt: time.Now().Add(s.deviceCodeLifetime).Unix(),
+	}
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	expireIn := int(s.deviceCodeLifetime.Seconds())
+	if err := s.store.Set(deviceCodeKey(deviceCode), string(encoded), expireIn); err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	if err := s.store.Set(userCodeIndexKey(userCode), deviceCode, expireIn); err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	verificationURI := s.baseURL.String() + "device"
+	json.NewEncoder(w).Encode(struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int    `json:"expires_in"`
+		Interval                int    `json:"interval"`
+	}{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: fmt.Sprintf("%s?user_code=%s", verificationURI, userCode),
+		ExpiresIn:               expireIn,
+		Interval:                int(s.pollInterval.Seconds()),
+	})
+}
+
+var deviceApprovalTemplate = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html><body>
+<form method="POST" action="/device">
+<label for="user_code">Code</label>
+<input type="text" id="user_code" name="user_code" value="{{.UserCode}}">
+<input type="submit" value="Continue">
+</form>
+</body></html>`))
+
+// deviceApprovalHandler implements GET/POST /device: a user enters the code
+// displayed on their device and is routed through one of the configured
+// IdPs to approve the pending device authorization request.
+type deviceApprovalHandler struct {
+	server *Server
+}
+
+func (h *deviceApprovalHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := h.server
+	if r.Method == http.MethodGet && r.URL.Query().Get("user_code") == "" {
+		deviceApprovalTemplate.Execute(w, struct{ UserCode string }{})
+		return
+	}
+	r.ParseForm()
+	userCode := strings.ToUpper(strings.TrimSpace(r.Form.Get("user_code")))
+	if userCode == "" {
+		userCode = strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("user_code")))
+	}
+	deviceCode, err := s.store.Get(userCodeIndexKey(userCode))
+	if err != nil {
+		http.Error(w, "unknown or expired code", http.StatusNotFound)
+		return
+	}
+	// Pick the first configured IdP to authenticate the approving user and
+	// hand the request off to the normal authentication redirect, carrying
+	// a single-use correlation id as the public state rather than the
+	// device_code itself.
+	var idpId string
+	var authn Authn
+	for id, a := range s.authn {
+		idpId, authn = id, a
+		break
+	}
+	if authn == nil {
+		http.Error(w, "no identity provider configured", http.StatusInternalServerError)
+		return
+	}
+	correlationID, err := randomToken(16)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.store.Set(deviceCallbackStateKey(correlationID), deviceCode, int(deviceCallbackStateLifetime.Seconds())); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	callbackURL, err := s.baseURL.Parse(fmt.Sprintf("device/callback?state=%s", correlationID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	redirectURL, idpState, err := authn.AuthnRedirect(callbackURL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.setDeviceIdPState(deviceCode, idpId, idpState); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+// deviceCallbackHandler implements GET /device/callback: the IdP's
+// redirect target, resolving the pending device record to an approved user.
+type deviceCallbackHandler struct {
+	server *Server
+}
+
+func (h *deviceCallbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := h.server
+	correlationID := r.URL.Query().Get("state")
+	deviceCode, err := s.store.Get(deviceCallbackStateKey(correlationID))
+	if err != nil {
+		http.Error(w, "unknown or expired code", http.StatusNotFound)
+		return
+	}
+	s.store.Delete(deviceCallbackStateKey(correlationID))
+	record, err := s.getDeviceRecord(deviceCode)
+	if err != nil {
+		http.Error(w, "unknown or expired code", http.StatusNotFound)
+		return
+	}
+	authn, ok := s.authn[record.IdP]
+	if !ok {
+		http.Error(w, "unknown identity provider", http.StatusInternalServerError)
+		return
+	}
+	user, err := authn.User(r, record.IdPState)
+	if err != nil {
+		record.Status = deviceStatusDenied
+		s.saveDeviceRecord(deviceCode, record)
+		http.Error(w, "authentication failed", http.StatusForbidden)
+		return
+	}
+	// Narrow the requested scope to what the approving user is actually
+	// authorized for; a device client can ask for anything, but approval
+	// only grants scopes in the approving user's scope set.
+	userScopes := s.authz.ScopeSetFor(user)
+	authorized := make([]string, 0, len(record.Scope))
+	for _, requested := range record.Scope {
+		if userScopes.ValidScope(requested) {
+			authorized = append(authorized, requested)
+		}
+	}
+	record.Scope = authorized
+	record.Status = deviceStatusApproved
+	record.UID = user.UID()
+	record.Roles = user.Roles()
+	if err := s.saveDeviceRecord(deviceCode, record); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, "You may now return to your device.")
+}
+
+func (s *Server) getDeviceRecord(deviceCode string) (*deviceRecord, error) {
+	encoded, err := s.store.Get(deviceCodeKey(deviceCode))
+	if err != nil {
+		return nil, err
+	}
+	var record deviceRecord
+	if err := json.Unmarshal([]byte(encoded), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *Server) saveDeviceRecord(deviceCode string, record *deviceRecord) error {
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	expireIn := int(time.Until(time.Unix(record.ExpiresAt, 0)).Seconds())
+	if expireIn < 0 {
+		expireIn = 0
+	}
+	return s.store.Set(deviceCodeKey(deviceCode), string(encoded), expireIn)
+}
+
+func (s *Server) setDeviceIdPState(deviceCode, idpId string, idpState []byte) error {
+	record, err := s.getDeviceRecord(deviceCode)
+	if err != nil {
+		return err
+	}
+	record.IdP = idpId
+	record.IdPState = idpState
+	return s.saveDeviceRecord(deviceCode, record)
+}
+
+// randomToken returns a random URL-safe opaque token of n bytes of entropy.
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", buf), nil
+}
+
+// randomUserCode returns a short, human-enterable code drawn from charset.
+func randomUserCode(charset string) (string, error) {
+	buf := make([]byte, userCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := make([]byte, userCodeLength)
+	for i, b := range buf {
+		code[i] = charset[int(b)%len(charset)]
+	}
+	return string(code), nil
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// allowListScopeSet only validates scopes present in allowed.
+type allowListScopeSet struct{ allowed map[string]bool }
+
+func (s *allowListScopeSet) ValidScope(scope ...string) bool {
+	for _, sc := range scope {
+		if !s.allowed[sc] {
+			return false
+		}
+	}
+	return true
+}
+
+type stubAuthz struct{ userScopes *allowListScopeSet }
+
+func (a *stubAuthz) ValidScope(scope ...string) bool      { return a.userScopes.ValidScope(scope...) }
+func (a *stubAuthz) ScopeSetFor(u User) ScopeSet          { return a.userScopes }
+func (a *stubAuthz) ScopeSetForClient(id string) ScopeSet { return a.userScopes }
+
+type fixedClientMap map[string]*Client
+
+func (m fixedClientMap) Get(id string) (*Client, error) {
+	if c, ok := m[id]; ok {
+		return c, nil
+	}
+	return nil, errNotFound
+}
+
+var errNotFound = &notFoundError{}
+
+type notFoundError struct{}
+
+func (*notFoundError) Error() string { return "client not found" }
+
+func newTestDeviceServer(t *testing.T) *Server {
+	t.Helper()
+	s := &Server{
+		store:                newMemStore(),
+		clientMap:            fixedClientMap{"device-client": {Id: "device-client", Secret: "shh"}},
+		authz:                &stubAuthz{userScopes: &allowListScopeSet{allowed: map[string]bool{"openid": true}}},
+		accessTokenEnc:       newAccessTokenEncoder([]byte("secret"), 3600, "goauth2"),
+		refreshStore:         newRefreshTokenMap(),
+		refreshTokenLifetime: time.Hour,
+		pollInterval:         0,
+	}
+	return s
+}
+
+// TestHandleDeviceCodeRequiresClientAuthentication verifies a device_code
+// cannot be redeemed without presenting valid client credentials, even if
+// the device_code itself is known and approved.
+func TestHandleDeviceCodeRequiresClientAuthentication(t *testing.T) {
+	s := newTestDeviceServer(t)
+	record := &deviceRecord{
+		ClientId:  "device-client",
+		Status:    deviceStatusApproved,
+		UID:       "user1",
+		Scope:     []string{"openid"},
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}
+	if err := s.saveDeviceRecord("devicecode1", record); err != nil {
+		t.Fatal(err)
+	}
+	h := &tokenHandler{s}
+
+	form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:device_code"}, "device_code": {"devicecode1"}}
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid_request without client_id, got %d: %s", w.Code, w.Body.String())
+	}
+
+	form.Set("client_id", "device-client")
+	form.Set("client_secret", "wrong-secret")
+	r = httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected invalid_client for wrong secret, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "invalid_client") {
+		t.Fatalf("expected invalid_client error body, got %s", w.Body.String())
+	}
+
+	form.Set("client_secret", "shh")
+	r = httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected success with correct client credentials, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestHandleDeviceCodePublicClientNeedsNoSecret verifies a client registered
+// with no secret (the public CLI/native-app clients this grant exists for)
+// can redeem an approved device_code by identifying itself with client_id
+// alone, per RFC 8628 section 3.4.
+func TestHandleDeviceCodePublicClientNeedsNoSecret(t *testing.T) {
+	s := newTestDeviceServer(t)
+	s.clientMap = fixedClientMap{"cli-client": {Id: "cli-client"}}
+	record := &deviceRecord{
+		ClientId:  "cli-client",
+		Status:    deviceStatusApproved,
+		UID:       "user1",
+		Scope:     []string{"openid"},
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}
+	if err := s.saveDeviceRecord("devicecode1", record); err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code": {"devicecode1"},
+		"client_id":   {"cli-client"},
+	}
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	(&tokenHandler{s}).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected success for a secretless public client, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestDeviceCallbackNarrowsScopeToUser verifies a device flow can't be used
+// to mint a token for scopes the approving user isn't authorized for, even
+// if the client requested them at /device_authorization.
+func TestDeviceCallbackNarrowsScopeToUser(t *testing.T) {
+	s := newTestDeviceServer(t)
+	s.authn = map[string]Authn{"test-idp": &fakeAuthn{user: &testUser{uid: "user1"}}}
+	record := &deviceRecord{
+		ClientId:  "device-client",
+		Status:    deviceStatusPending,
+		Scope:     []string{"openid", "admin"},
+		IdP:       "test-idp",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}
+	if err := s.saveDeviceRecord("devicecode1", record); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.Set(deviceCallbackStateKey("correlation1"), "devicecode1", 3600); err != nil {
+		t.Fatal(err)
+	}
+	h := &deviceCallbackHandler{s}
+	r := httptest.NewRequest(http.MethodGet, "/device/callback?state=correlation1&code=authzcode", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback failed: %d: %s", w.Code, w.Body.String())
+	}
+	saved, err := s.getDeviceRecord("devicecode1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Scope) != 1 || saved.Scope[0] != "openid" {
+		t.Fatalf("expected scope narrowed to [openid], got %v", saved.Scope)
+	}
+}
+
+type fakeAuthn struct{ user User }
+
+func (a *fakeAuthn) AuthnRedirect(callbackURL *url.URL) (*url.URL, []byte, error) {
+	return callbackURL, nil, nil
+}
+func (a *fakeAuthn) User(r *http.Request, state []byte) (User, error) { return a.user, nil }
+
+// capturingAuthn records the callbackURL it was asked to redirect to, so
+// tests can inspect what was sent to the "IdP".
+type capturingAuthn struct {
+	capturedCallbackURL *url.URL
+}
+
+func (a *capturingAuthn) AuthnRedirect(callbackURL *url.URL) (*url.URL, []byte, error) {
+	a.capturedCallbackURL = callbackURL
+	return callbackURL, nil, nil
+}
+func (a *capturingAuthn) User(r *http.Request, state []byte) (User, error) { return nil, nil }
+
+// TestDeviceApprovalHandlerDoesNotLeakDeviceCodeAsState verifies the
+// device_code never appears in the public state parameter sent to the IdP;
+// a single-use correlation id stands in for it instead.
+func TestDeviceApprovalHandlerDoesNotLeakDeviceCodeAsState(t *testing.T) {
+	s := newTestDeviceServer(t)
+	s.baseURL = url.URL{Scheme: "https", Host: "auth.example", Path: "/"}
+	authn := &capturingAuthn{}
+	s.authn = map[string]Authn{"test-idp": authn}
+	if err := s.store.Set(userCodeIndexKey("ABCD1234"), "devicecode1", 600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.saveDeviceRecord("devicecode1", &deviceRecord{
+		ClientId:  "device-client",
+		Status:    deviceStatusPending,
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	h := &deviceApprovalHandler{s}
+	r := httptest.NewRequest(http.MethodPost, "/device", strings.NewReader(url.Values{"user_code": {"ABCD1234"}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected a redirect, got %d: %s", w.Code, w.Body.String())
+	}
+	if authn.capturedCallbackURL == nil {
+		t.Fatal("expected AuthnRedirect to be called")
+	}
+	state := authn.capturedCallbackURL.Query().Get("state")
+	if state == "" {
+		t.Fatal("expected a state parameter")
+	}
+	if state == "devicecode1" {
+		t.Fatal("device_code must not be used as the public state parameter")
+	}
+	if _, err := s.store.Get(deviceCallbackStateKey(state)); err != nil {
+		t.Fatalf("expected the state to resolve back to the device_code via TransientStorage, got %s", err)
+	}
+}
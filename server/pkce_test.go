@@ -0,0 +1,126 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	verifier := "a-random-verifier-of-sufficient-length"
+	sum := sha256.Sum256([]byte(verifier))
+	s256Challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	cases := []struct {
+		name      string
+		challenge string
+		method    string
+		verifier  string
+		want      bool
+	}{
+		{"valid S256", s256Challenge, "S256", verifier, true},
+		{"wrong verifier S256", s256Challenge, "S256", "not-the-verifier", false},
+		{"valid plain", verifier, "plain", verifier, true},
+		{"valid plain, empty method defaults to plain", verifier, "", verifier, true},
+		{"wrong verifier plain", verifier, "plain", "not-the-verifier", false},
+		{"missing verifier", s256Challenge, "S256", "", false},
+		{"unsupported method", verifier, "S512", verifier, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := verifyPKCE(c.challenge, c.method, c.verifier); got != c.want {
+				t.Errorf("verifyPKCE(%q, %q, %q) = %v, want %v", c.challenge, c.method, c.verifier, got, c.want)
+			}
+		})
+	}
+}
+
+// memStore is a minimal TransientStorage used to test the issued-code round
+// trip without a real backend.
+type memStore struct {
+	data map[string]string
+}
+
+func newMemStore() *memStore { return &memStore{data: make(map[string]string)} }
+
+func (m *memStore) Set(key, value string, expireIn int) error {
+	m.data[key] = value
+	return nil
+}
+
+func (m *memStore) Get(key string) (string, error) {
+	v, ok := m.data[key]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return v, nil
+}
+
+func (m *memStore) Delete(key string) error {
+	delete(m.data, key)
+	return nil
+}
+
+type testUser struct{ uid string }
+
+func (u *testUser) UID() string     { return u.uid }
+func (u *testUser) Roles() []string { return nil }
+
+// TestAuthorizationCodePKCERoundTrip verifies the challenge captured from
+// the /authorize request by newAuthorizationState survives through
+// issueAuthorizationCode into the persisted codeGrant, so it can still be
+// enforced when the code is redeemed at /token.
+func TestAuthorizationCodePKCERoundTrip(t *testing.T) {
+	client := &Client{Id: "spa-client", RequirePKCE: true}
+	r := &http.Request{URL: &url.URL{RawQuery: url.Values{
+		"redirect_uri":          {"https://app.example/cb"},
+		"response_type":         {"code"},
+		"scope":                 {"openid profile"},
+		"state":                 {"xyz"},
+		"code_challenge":        {"abc123"},
+		"code_challenge_method": {"S256"},
+	}.Encode()}}
+
+	state, err := newAuthorizationState(r, client)
+	if err != nil {
+		t.Fatalf("newAuthorizationState: %s", err)
+	}
+	if state.CodeChallenge != "abc123" || state.CodeChallengeMethod != "S256" {
+		t.Fatalf("PKCE challenge not captured: %+v", state)
+	}
+
+	s := &Server{store: newMemStore()}
+	code, err := s.issueAuthorizationCode(state, &testUser{uid: "user1"})
+	if err != nil {
+		t.Fatalf("issueAuthorizationCode: %s", err)
+	}
+	encoded, err := s.store.Get("code:" + code)
+	if err != nil {
+		t.Fatalf("issued code not persisted: %s", err)
+	}
+	var grant codeGrant
+	if err := json.Unmarshal([]byte(encoded), &grant); err != nil {
+		t.Fatal(err)
+	}
+	if grant.CodeChallenge != "abc123" || grant.CodeChallengeMethod != "S256" {
+		t.Errorf("PKCE challenge lost between authorizationState and codeGrant: %+v", grant)
+	}
+}
+
+// TestNewAuthorizationStateRequiresPKCEForPublicClients verifies that a
+// client configured with RequirePKCE cannot start an authorization request
+// without a code_challenge.
+func TestNewAuthorizationStateRequiresPKCEForPublicClients(t *testing.T) {
+	client := &Client{Id: "spa-client", RequirePKCE: true}
+	r := &http.Request{URL: &url.URL{RawQuery: url.Values{
+		"redirect_uri":  {"https://app.example/cb"},
+		"response_type": {"code"},
+	}.Encode()}}
+	if _, err := newAuthorizationState(r, client); err == nil {
+		t.Error("expected an error when a PKCE-required client omits code_challenge")
+	}
+}
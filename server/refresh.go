@@ -0,0 +1,137 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// RefreshTokenRecord is the data persisted for an issued refresh token.
+type RefreshTokenRecord struct {
+	ClientId       string
+	UID            string
+	Scope          []string
+	IssuedAt       int64
+	AbsoluteExpiry int64
+}
+
+// RefreshTokenStore is implemented by storage providers and used to persist
+// refresh token records. Like TransientStorage, it's backed by an in-memory
+// map by default and can be swapped for a Redis-backed implementation in
+// production.
+type RefreshTokenStore interface {
+	Set(key string, value string, expireIn int) error
+	Get(key string) (string, error)
+	Delete(key string) error
+}
+
+// refreshTokenMap is the default, in-memory RefreshTokenStore.
+type refreshTokenMap struct {
+	mutex sync.RWMutex
+	data  map[string]string
+}
+
+func newRefreshTokenMap() *refreshTokenMap {
+	return &refreshTokenMap{data: make(map[string]string)}
+}
+
+func (m *refreshTokenMap) Set(key string, value string, expireIn int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.data[key] = value
+	return nil
+}
+
+func (m *refreshTokenMap) Get(key string) (string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	value, ok := m.data[key]
+	if !ok {
+		return "", errors.New("refresh token not found")
+	}
+	return value, nil
+}
+
+func (m *refreshTokenMap) Delete(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+// newRefreshToken generates a random opaque refresh token and the key under
+// which its record is stored (a hash of the token, so the store never holds
+// the bearer value itself).
+func newRefreshToken() (token string, key string, err error) {
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, refreshTokenKey(token), nil
+}
+
+// refreshTokenKey hashes a refresh token to the key it's stored under.
+func refreshTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "refresh:" + base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// issueRefreshToken persists a new refresh token record for the given
+// grant and returns the bearer token to send to the client.
+func (s *Server) issueRefreshToken(clientId, uid string, scope []string) (string, error) {
+	token, key, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	record := RefreshTokenRecord{
+		ClientId:       clientId,
+		UID:            uid,
+		Scope:          scope,
+		IssuedAt:       now.Unix(),
+		AbsoluteExpiry: now.Add(s.refreshTokenLifetime).Unix(),
+	}
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	if err := s.refreshStore.Set(key, string(encoded), int(s.refreshTokenLifetime.Seconds())); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeRefreshToken looks up and validates the record for a refresh
+// token presented by clientId, rotating (deleting) it when
+// RotateRefreshTokens is enabled. Ownership is checked before any deletion:
+// an attacker who only knows another client's leaked token value must not
+// be able to rotate it away and deny service to the legitimate owner.
+func (s *Server) consumeRefreshToken(token, clientId string) (*RefreshTokenRecord, error) {
+	key := refreshTokenKey(token)
+	encoded, err := s.refreshStore.Get(key)
+	if err != nil {
+		return nil, errors.New("invalid_grant")
+	}
+	var record RefreshTokenRecord
+	if err := json.Unmarshal([]byte(encoded), &record); err != nil {
+		return nil, err
+	}
+	if record.ClientId != clientId {
+		return nil, errors.New("invalid_grant")
+	}
+	if time.Now().Unix() > record.AbsoluteExpiry {
+		s.refreshStore.Delete(key)
+		return nil, errors.New("invalid_grant")
+	}
+	if s.rotateRefreshTokens {
+		if err := s.refreshStore.Delete(key); err != nil {
+			return nil, err
+		}
+	}
+	return &record, nil
+}
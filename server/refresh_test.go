@@ -0,0 +1,195 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRefreshServer(rotate bool) *Server {
+	return &Server{
+		store:     newMemStore(),
+		clientMap: fixedClientMap{"web-client": {Id: "web-client", Secret: "shh"}},
+		authz: &stubAuthz{userScopes: &allowListScopeSet{allowed: map[string]bool{
+			"openid": true, "profile": true,
+		}}},
+		accessTokenEnc:       newAccessTokenEncoder([]byte("secret"), 3600, "goauth2"),
+		refreshStore:         newRefreshTokenMap(),
+		refreshTokenLifetime: time.Hour,
+		rotateRefreshTokens:  rotate,
+	}
+}
+
+// TestIssueAndConsumeRefreshToken verifies a token issued by
+// issueRefreshToken round-trips through consumeRefreshToken with its
+// original grant intact.
+func TestIssueAndConsumeRefreshToken(t *testing.T) {
+	s := newTestRefreshServer(false)
+	token, err := s.issueRefreshToken("web-client", "user1", []string{"openid", "profile"})
+	if err != nil {
+		t.Fatalf("issueRefreshToken: %s", err)
+	}
+	record, err := s.consumeRefreshToken(token, "web-client")
+	if err != nil {
+		t.Fatalf("consumeRefreshToken: %s", err)
+	}
+	if record.ClientId != "web-client" || record.UID != "user1" {
+		t.Fatalf("unexpected record: %+v", record)
+	}
+	// Rotation disabled: the same token can be consumed again.
+	if _, err := s.consumeRefreshToken(token, "web-client"); err != nil {
+		t.Fatalf("expected token to remain valid without rotation, got %s", err)
+	}
+}
+
+// TestConsumeRefreshTokenRotation verifies that with rotation enabled, a
+// consumed refresh token cannot be replayed.
+func TestConsumeRefreshTokenRotation(t *testing.T) {
+	s := newTestRefreshServer(true)
+	token, err := s.issueRefreshToken("web-client", "user1", []string{"openid"})
+	if err != nil {
+		t.Fatalf("issueRefreshToken: %s", err)
+	}
+	if _, err := s.consumeRefreshToken(token, "web-client"); err != nil {
+		t.Fatalf("first consume: %s", err)
+	}
+	if _, err := s.consumeRefreshToken(token, "web-client"); err == nil {
+		t.Fatal("expected replayed refresh token to be rejected after rotation")
+	}
+}
+
+// TestConsumeRefreshTokenExpired verifies a refresh token past its absolute
+// expiry is rejected even though the underlying store entry hasn't expired.
+func TestConsumeRefreshTokenExpired(t *testing.T) {
+	s := newTestRefreshServer(false)
+	token, key, err := newRefreshToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := RefreshTokenRecord{
+		ClientId:       "web-client",
+		UID:            "user1",
+		Scope:          []string{"openid"},
+		IssuedAt:       time.Now().Add(-2 * time.Hour).Unix(),
+		AbsoluteExpiry: time.Now().Add(-time.Hour).Unix(),
+	}
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.refreshStore.Set(key, string(encoded), 3600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.consumeRefreshToken(token, "web-client"); err == nil {
+		t.Fatal("expected expired refresh token to be rejected")
+	}
+}
+
+// TestConsumeRefreshTokenWrongClientDoesNotRotate verifies that presenting a
+// refresh token with the wrong clientId is rejected without rotating the
+// token away -- otherwise an attacker who only knows another client's
+// leaked token value could use a failed, correctly-authenticated request of
+// their own to deny service to the legitimate owner.
+func TestConsumeRefreshTokenWrongClientDoesNotRotate(t *testing.T) {
+	s := newTestRefreshServer(true)
+	token, err := s.issueRefreshToken("victim-client", "user1", []string{"openid"})
+	if err != nil {
+		t.Fatalf("issueRefreshToken: %s", err)
+	}
+	if _, err := s.consumeRefreshToken(token, "attacker-client"); err == nil {
+		t.Fatal("expected refresh token to be rejected for the wrong client")
+	}
+	if _, err := s.consumeRefreshToken(token, "victim-client"); err != nil {
+		t.Fatalf("expected the legitimate owner's token to still be valid, got %s", err)
+	}
+}
+
+func postRefreshToken(s *Server, form url.Values) *httptest.ResponseRecorder {
+	form.Set("grant_type", "refresh_token")
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	(&tokenHandler{s}).ServeHTTP(w, r)
+	return w
+}
+
+// TestHandleRefreshTokenNarrowsScope verifies a refresh request can narrow
+// but not widen the scope granted to the original token.
+func TestHandleRefreshTokenNarrowsScope(t *testing.T) {
+	s := newTestRefreshServer(false)
+	token, err := s.issueRefreshToken("web-client", "user1", []string{"openid", "profile"})
+	if err != nil {
+		t.Fatalf("issueRefreshToken: %s", err)
+	}
+	w := postRefreshToken(s, url.Values{
+		"client_id":     {"web-client"},
+		"client_secret": {"shh"},
+		"refresh_token": {token},
+		"scope":         {"openid"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"scope":"openid"`) {
+		t.Fatalf("expected scope narrowed to 'openid', got %s", w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "profile") {
+		t.Fatalf("expected 'profile' to be dropped, got %s", w.Body.String())
+	}
+}
+
+// TestHandleRefreshTokenRotatesWhenEnabled verifies that with
+// RotateRefreshTokens enabled, a successful refresh both returns a new
+// refresh token and invalidates the one it was given.
+func TestHandleRefreshTokenRotatesWhenEnabled(t *testing.T) {
+	s := newTestRefreshServer(true)
+	token, err := s.issueRefreshToken("web-client", "user1", []string{"openid"})
+	if err != nil {
+		t.Fatalf("issueRefreshToken: %s", err)
+	}
+	w := postRefreshToken(s, url.Values{
+		"client_id":     {"web-client"},
+		"client_secret": {"shh"},
+		"refresh_token": {token},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), `"refresh_token":""`) || !strings.Contains(w.Body.String(), "refresh_token") {
+		t.Fatalf("expected a new refresh token in the response, got %s", w.Body.String())
+	}
+	w2 := postRefreshToken(s, url.Values{
+		"client_id":     {"web-client"},
+		"client_secret": {"shh"},
+		"refresh_token": {token},
+	})
+	if w2.Code != http.StatusBadRequest {
+		t.Fatalf("expected the old refresh token to be rejected after rotation, got %d: %s", w2.Code, w2.Body.String())
+	}
+}
+
+// TestHandleRefreshTokenWrongClient verifies a refresh token cannot be
+// redeemed by a client other than the one it was issued to.
+func TestHandleRefreshTokenWrongClient(t *testing.T) {
+	s := newTestRefreshServer(false)
+	s.clientMap = fixedClientMap{
+		"web-client":   {Id: "web-client", Secret: "shh"},
+		"other-client": {Id: "other-client", Secret: "shh2"},
+	}
+	token, err := s.issueRefreshToken("web-client", "user1", []string{"openid"})
+	if err != nil {
+		t.Fatalf("issueRefreshToken: %s", err)
+	}
+	w := postRefreshToken(s, url.Values{
+		"client_id":     {"other-client"},
+		"client_secret": {"shh2"},
+		"refresh_token": {token},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected refresh token issued to a different client to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+}
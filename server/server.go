@@ -10,8 +10,12 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 )
 
+// defaultRefreshTokenLifetime is used when RefreshTokenLifetime isn't set.
+const defaultRefreshTokenLifetime = 30 * 24 * time.Hour
+
 type Server struct {
 	baseURL  url.URL
 	listener net.Listener
@@ -25,6 +29,16 @@ type Server struct {
 	authn     map[string]Authn
 	clientMap ClientMap
 
+	// Refresh tokens
+	refreshStore         RefreshTokenStore
+	refreshTokenLifetime time.Duration
+	rotateRefreshTokens  bool
+
+	// Device authorization grant
+	deviceCodeLifetime time.Duration
+	pollInterval       time.Duration
+	userCodeCharset    string
+
 	// Concurrency control
 	clientMutex sync.RWMutex
 	once        sync.Once
@@ -62,6 +76,25 @@ func New(options ...Option) (*Server, error) {
 		log.Println("WARN: using anonymous authentication")
 		s.authn["anonymous"] = &anonymousIdP{}
 	}
+	// Set default refresh token store if none given
+	if s.refreshStore == nil {
+		log.Println("WARN: Using in-memory refresh token storage")
+		s.refreshStore = newRefreshTokenMap()
+	}
+	// Set default refresh token lifetime if none given
+	if s.refreshTokenLifetime == 0 {
+		s.refreshTokenLifetime = defaultRefreshTokenLifetime
+	}
+	// Set device authorization grant defaults if none given
+	if s.deviceCodeLifetime == 0 {
+		s.deviceCodeLifetime = defaultDeviceCodeLifetime
+	}
+	if s.pollInterval == 0 {
+		s.pollInterval = defaultPollInterval
+	}
+	if s.userCodeCharset == "" {
+		s.userCodeCharset = defaultUserCodeCharset
+	}
 	s.initialized = true
 	return s, nil
 }
@@ -125,6 +158,12 @@ func (s *Server) handler() (http.Handler, error) {
 	// Create authorization handler
 	authzHandler := &authorizationHandler{s.clientMap, s.authz, idps}
 	mux.Handle("/authorize", authzHandler)
+	// Create token handler
+	mux.Handle("/token", &tokenHandler{s})
+	// Create device authorization grant handlers
+	mux.Handle("/device_authorization", &deviceAuthorizationHandler{s})
+	mux.Handle("/device", &deviceApprovalHandler{s})
+	mux.Handle("/device/callback", &deviceCallbackHandler{s})
 	return mux, nil
 }
 
@@ -133,6 +172,7 @@ func (s *Server) handler() (http.Handler, error) {
 type TransientStorage interface {
 	Set(key string, value string, expireIn int) error
 	Get(key string) (string, error)
+	Delete(key string) error
 }
 
 // Interface User is implemented by identity providers and used by
@@ -167,6 +207,10 @@ type Authz interface {
 	ScopeSet
 	// ScopeSetFor() returns the given user's authorized scopeset.
 	ScopeSetFor(u User) ScopeSet
+	// ScopeSetForClient() returns the scopeset a client is authorized to
+	// request for itself, used by the client_credentials grant. Providers
+	// that don't support machine-to-machine scopes can return an empty set.
+	ScopeSetForClient(clientId string) ScopeSet
 }
 
 // The Client type contains all data needed for OAuth 2.0 clients.
@@ -179,6 +223,11 @@ type Client struct {
 	Secret string
 	// Allowed grants (implicit, authz code, client credentials)
 	GrantType string
+	// RequirePKCE forces the client to present a PKCE
+	// code_challenge/code_verifier pair on the authorization code flow. A
+	// client with the flag and no Secret may use the code_verifier in place
+	// of a secret at /token; a client with a Secret must still present it.
+	RequirePKCE bool
 }
 
 // The ClientMap interface is implemented for OAuth 2.0 clients and used to
@@ -252,6 +301,80 @@ func AccessTokenConfig(secret []byte, lifetime int64, issuer string) Option {
 	}
 }
 
+// RefreshTokenStorage() is an option that sets the storage backend for
+// issued refresh tokens. Defaults to an in-memory store.
+func RefreshTokenStorage(store RefreshTokenStore) Option {
+	return func(s *Server) error {
+		if s.initialized {
+			return errors.New("Given server already initialized")
+		}
+		s.refreshStore = store
+		return nil
+	}
+}
+
+// RefreshTokenLifetime() is an option that sets the absolute lifetime of
+// issued refresh tokens.
+func RefreshTokenLifetime(lifetime time.Duration) Option {
+	return func(s *Server) error {
+		if s.initialized {
+			return errors.New("Given server already initialized")
+		}
+		s.refreshTokenLifetime = lifetime
+		return nil
+	}
+}
+
+// RotateRefreshTokens() is an option that, when enabled, invalidates a
+// refresh token as soon as it's used and issues a new one alongside the
+// refreshed access token. Disabled by default.
+func RotateRefreshTokens(rotate bool) Option {
+	return func(s *Server) error {
+		if s.initialized {
+			return errors.New("Given server already initialized")
+		}
+		s.rotateRefreshTokens = rotate
+		return nil
+	}
+}
+
+// DeviceCodeLifetime() is an option that sets how long a device
+// authorization request remains pending before it expires.
+func DeviceCodeLifetime(lifetime time.Duration) Option {
+	return func(s *Server) error {
+		if s.initialized {
+			return errors.New("Given server already initialized")
+		}
+		s.deviceCodeLifetime = lifetime
+		return nil
+	}
+}
+
+// PollInterval() is an option that sets the minimum interval a device
+// client must wait between polls of the device_code grant.
+func PollInterval(interval time.Duration) Option {
+	return func(s *Server) error {
+		if s.initialized {
+			return errors.New("Given server already initialized")
+		}
+		s.pollInterval = interval
+		return nil
+	}
+}
+
+// UserCodeCharset() is an option that sets the alphabet used to generate
+// user codes for the device authorization grant. Defaults to an
+// unambiguous uppercase/digit charset.
+func UserCodeCharset(charset string) Option {
+	return func(s *Server) error {
+		if s.initialized {
+			return errors.New("Given server already initialized")
+		}
+		s.userCodeCharset = charset
+		return nil
+	}
+}
+
 // IdP is an option that adds the given IdP to this server. If the IdP was
 // already registered it will be silently overwritten.
 func IdP(id string, a Authn) Option {
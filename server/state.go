@@ -13,6 +13,10 @@ type authorizationState struct {
 	Scope        []string
 	State        string
 	IdPState     []byte
+	// PKCE (RFC 7636), set when the /authorize request carried a
+	// code_challenge. CodeChallengeMethod is "S256" or "plain".
+	CodeChallenge       string
+	CodeChallengeMethod string
 }
 
 type stateStorage struct {
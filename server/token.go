@@ -0,0 +1,402 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// tokenResponse is the JSON body returned from a successful /token request.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// tokenErrorResponse is the error body shape defined by RFC 6749 section
+// 5.2.
+type tokenErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// tokenHandler implements the /token endpoint. It dispatches on grant_type
+// to the authorization_code, refresh_token, client_credentials and
+// device_code grants; any other grant_type is answered with
+// unsupported_grant_type.
+type tokenHandler struct {
+	server *Server
+}
+
+func (h *tokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	switch r.Form.Get("grant_type") {
+	case "authorization_code":
+		h.handleAuthorizationCode(w, r)
+	case "refresh_token":
+		h.handleRefreshToken(w, r)
+	case "client_credentials":
+		h.handleClientCredentials(w, r)
+	case "urn:ietf:params:oauth:grant-type:device_code":
+		h.handleDeviceCode(w, r)
+	default:
+		writeTokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+	}
+}
+
+// handleDeviceCode implements the device_code grant (RFC 8628 section
+// 3.4): the client polls with the device_code it was issued until the user
+// has approved (or denied) the request on a separate device.
+func (h *tokenHandler) handleDeviceCode(w http.ResponseWriter, r *http.Request) {
+	s := h.server
+	clientId, secret, ok := clientCredentials(r)
+	if !ok {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	client, err := s.clientMap.Get(clientId)
+	if err != nil {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	// Device clients are typically public (CLIs, input-constrained devices)
+	// and have no secret to present; RFC 8628 section 3.4 only requires them
+	// to identify themselves, which record.ClientId != clientId below does.
+	// Confidential clients that do have a secret still must present it.
+	if client.Secret != "" && subtle.ConstantTimeCompare([]byte(client.Secret), []byte(secret)) != 1 {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	deviceCode := r.Form.Get("device_code")
+	if deviceCode == "" {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	record, err := s.getDeviceRecord(deviceCode)
+	if err != nil {
+		writeTokenError(w, http.StatusBadRequest, "expired_token")
+		return
+	}
+	if record.ClientId != clientId {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	now := time.Now()
+	if now.Unix() > record.ExpiresAt {
+		writeTokenError(w, http.StatusBadRequest, "expired_token")
+		return
+	}
+	if now.Unix()-record.LastPolled < int64(s.pollInterval.Seconds()) {
+		writeTokenError(w, http.StatusBadRequest, "slow_down")
+		return
+	}
+	record.LastPolled = now.Unix()
+	switch record.Status {
+	case deviceStatusDenied:
+		s.saveDeviceRecord(deviceCode, record)
+		writeTokenError(w, http.StatusBadRequest, "access_denied")
+		return
+	case deviceStatusPending:
+		s.saveDeviceRecord(deviceCode, record)
+		writeTokenError(w, http.StatusBadRequest, "authorization_pending")
+		return
+	}
+	if err := s.store.Delete(deviceCodeKey(deviceCode)); err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	accessToken, err := s.accessTokenEnc.Encode(record.UID, record.ClientId, record.Scope)
+	if err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	refreshToken, err := s.issueRefreshToken(record.ClientId, record.UID, record.Scope)
+	if err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	writeTokenResponse(w, tokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "bearer",
+		ExpiresIn:    s.accessTokenEnc.lifetime,
+		RefreshToken: refreshToken,
+		Scope:        joinScope(record.Scope),
+	})
+}
+
+// handleClientCredentials implements the grant_type=client_credentials
+// branch (RFC 6749 section 4.4) for machine-to-machine auth: the client
+// authenticates itself, the requested scope is narrowed to what it's
+// authorized for, and an access token is minted with no associated user.
+func (h *tokenHandler) handleClientCredentials(w http.ResponseWriter, r *http.Request) {
+	s := h.server
+	clientId, secret, ok := clientCredentials(r)
+	if !ok {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	client, err := s.clientMap.Get(clientId)
+	if err != nil {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	// A client with no configured secret can't present one to match, so an
+	// empty-secret request would otherwise authenticate as it. client_credentials
+	// is the confidential-client grant; reject it outright rather than treat the
+	// client as authenticated.
+	if client.Secret == "" {
+		writeTokenError(w, http.StatusUnauthorized, "unauthorized_client")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(client.Secret), []byte(secret)) != 1 {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if !clientAllowsGrant(client, "client_credentials") {
+		writeTokenError(w, http.StatusBadRequest, "unauthorized_client")
+		return
+	}
+	clientScopes := s.authz.ScopeSetForClient(clientId)
+	scope := make([]string, 0)
+	for _, requested := range splitScope(r.Form.Get("scope")) {
+		if clientScopes.ValidScope(requested) {
+			scope = append(scope, requested)
+		}
+	}
+	accessToken, err := s.accessTokenEnc.Encode("", clientId, scope)
+	if err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	writeTokenResponse(w, tokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "bearer",
+		ExpiresIn:   s.accessTokenEnc.lifetime,
+		Scope:       joinScope(scope),
+	})
+}
+
+// clientAllowsGrant reports whether client.GrantType, a space-separated
+// list like the Scope fields elsewhere in this package, includes grant.
+func clientAllowsGrant(client *Client, grant string) bool {
+	for _, g := range strings.Fields(client.GrantType) {
+		if g == grant {
+			return true
+		}
+	}
+	return false
+}
+
+// codeGrant is the record persisted under the authorization code by the
+// authorization endpoint once a user has approved the request.
+type codeGrant struct {
+	ClientId    string
+	RedirectURI string
+	UID         string
+	Scope       []string
+	// PKCE (RFC 7636), carried over from the authorizationState that
+	// produced this code.
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// handleAuthorizationCode implements the grant_type=authorization_code
+// branch: the client authenticates, the single-use code is resolved to its
+// grant and exchanged for an access token plus a refresh token.
+func (h *tokenHandler) handleAuthorizationCode(w http.ResponseWriter, r *http.Request) {
+	s := h.server
+	clientId, secret, ok := clientCredentials(r)
+	if !ok {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	client, err := s.clientMap.Get(clientId)
+	if err != nil {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	code := r.Form.Get("code")
+	if code == "" {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	encoded, err := s.store.Get("code:" + code)
+	if err != nil {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	var grant codeGrant
+	if err := json.Unmarshal([]byte(encoded), &grant); err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	// Public clients (no secret) may authenticate with PKCE instead, but
+	// only when they have no secret to present in the first place and the
+	// original /authorize request carried a code_challenge. A confidential
+	// client that also opts into RequirePKCE as defense-in-depth still must
+	// present its secret.
+	pkce := grant.CodeChallenge != ""
+	pkceInLieuOfSecret := client.Secret == "" && client.RequirePKCE && pkce
+	if !pkceInLieuOfSecret {
+		if subtle.ConstantTimeCompare([]byte(client.Secret), []byte(secret)) != 1 {
+			writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+			return
+		}
+	}
+	if grant.ClientId != clientId || grant.RedirectURI != r.Form.Get("redirect_uri") {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	if pkce {
+		if !verifyPKCE(grant.CodeChallenge, grant.CodeChallengeMethod, r.Form.Get("code_verifier")) {
+			writeTokenError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+	} else if client.RequirePKCE {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	if err := s.store.Delete("code:" + code); err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	accessToken, err := s.accessTokenEnc.Encode(grant.UID, clientId, grant.Scope)
+	if err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	refreshToken, err := s.issueRefreshToken(clientId, grant.UID, grant.Scope)
+	if err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	writeTokenResponse(w, tokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "bearer",
+		ExpiresIn:    s.accessTokenEnc.lifetime,
+		RefreshToken: refreshToken,
+		Scope:        joinScope(grant.Scope),
+	})
+}
+
+// handleRefreshToken implements the grant_type=refresh_token branch: the
+// client authenticates, the refresh token record is looked up and
+// (optionally) rotated, and a fresh access token is minted. Scopes may be
+// narrowed but never widened relative to the original grant.
+func (h *tokenHandler) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	s := h.server
+	clientId, secret, ok := clientCredentials(r)
+	if !ok {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	client, err := s.clientMap.Get(clientId)
+	if err != nil || subtle.ConstantTimeCompare([]byte(client.Secret), []byte(secret)) != 1 {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	refreshToken := r.Form.Get("refresh_token")
+	if refreshToken == "" {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	record, err := s.consumeRefreshToken(refreshToken, clientId)
+	if err != nil {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	scope := record.Scope
+	if requested := r.Form.Get("scope"); requested != "" {
+		scope = intersectScope(record.Scope, splitScope(requested))
+	}
+	accessToken, err := s.accessTokenEnc.Encode(record.UID, clientId, scope)
+	if err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	resp := tokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "bearer",
+		ExpiresIn:   s.accessTokenEnc.lifetime,
+		Scope:       joinScope(scope),
+	}
+	if s.rotateRefreshTokens {
+		if resp.RefreshToken, err = s.issueRefreshToken(clientId, record.UID, scope); err != nil {
+			writeTokenError(w, http.StatusInternalServerError, "server_error")
+			return
+		}
+	}
+	writeTokenResponse(w, resp)
+}
+
+// verifyPKCE checks a code_verifier against the code_challenge persisted for
+// an authorization code, per RFC 7636 section 4.6.
+func verifyPKCE(challenge, method, verifier string) bool {
+	if verifier == "" {
+		return false
+	}
+	switch method {
+	case "S256":
+		sum := sha256.Sum256([]byte(verifier))
+		return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+	case "plain", "":
+		return subtle.ConstantTimeCompare([]byte(verifier), []byte(challenge)) == 1
+	default:
+		return false
+	}
+}
+
+// clientCredentials extracts client_id/client_secret from HTTP Basic auth or
+// the request body, as allowed by RFC 6749 section 2.3.1.
+func clientCredentials(r *http.Request) (id string, secret string, ok bool) {
+	if id, secret, ok = r.BasicAuth(); ok {
+		return
+	}
+	id = r.Form.Get("client_id")
+	secret = r.Form.Get("client_secret")
+	return id, secret, id != ""
+}
+
+func splitScope(scope string) []string {
+	return strings.Fields(scope)
+}
+
+func joinScope(scope []string) string {
+	return strings.Join(scope, " ")
+}
+
+// intersectScope narrows granted to the subset also present in requested.
+func intersectScope(granted []string, requested []string) []string {
+	allowed := make(map[string]bool, len(granted))
+	for _, s := range granted {
+		allowed[s] = true
+	}
+	narrowed := make([]string, 0, len(requested))
+	for _, s := range requested {
+		if allowed[s] {
+			narrowed = append(narrowed, s)
+		}
+	}
+	return narrowed
+}
+
+func writeTokenResponse(w http.ResponseWriter, resp tokenResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func writeTokenError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(tokenErrorResponse{Error: code})
+}